@@ -0,0 +1,117 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+//
+// This collector exposes the per-NUMA-node vmstat counters found under
+// '/sys/devices/system/node/node*/vmstat', one metric per field, named
+// after the field as it appears in that file (nr_zone_inactive_anon,
+// nr_zone_active_anon, pgsteal_kswapd, ...) and labeled by node. Since
+// kernel 4.8 LRU accounting is done per node rather than per zone, and
+// these files report it per node.
+
+// +build !novmstat_numa
+
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	vmStatNUMASubsystem = "vmstat_numa"
+)
+
+var nodeDirRE = regexp.MustCompile(`^node(\d+)$`)
+
+type vmStatNUMACollector struct {
+	metricDescs map[string]*prometheus.Desc
+}
+
+func init() {
+	registerCollector("vmstat_numa", defaultEnabled, NewVMStatNUMACollector)
+}
+
+// NewVMStatNUMACollector returns a new Collector exposing per-node vmstat stats.
+func NewVMStatNUMACollector() (Collector, error) {
+	return &vmStatNUMACollector{metricDescs: map[string]*prometheus.Desc{}}, nil
+}
+
+func (c *vmStatNUMACollector) desc(field string) *prometheus.Desc {
+	if d, ok := c.metricDescs[field]; ok {
+		return d
+	}
+	d := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, vmStatNUMASubsystem, field),
+		fmt.Sprintf("Per-node vmstat counter %s from /sys/devices/system/node/node*/vmstat.", field),
+		[]string{"node"}, nil,
+	)
+	c.metricDescs[field] = d
+	return d
+}
+
+func (c *vmStatNUMACollector) Update(ch chan<- prometheus.Metric) error {
+	nodeDirs, err := os.ReadDir(sysFilePath("devices/system/node"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoData
+		}
+		return err
+	}
+
+	for _, nodeDir := range nodeDirs {
+		matches := nodeDirRE.FindStringSubmatch(nodeDir.Name())
+		if matches == nil {
+			continue
+		}
+		node := matches[1]
+
+		if err := c.updateNode(ch, node, nodeDir.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *vmStatNUMACollector) updateNode(ch chan<- prometheus.Metric, node, dirName string) error {
+	file, err := os.Open(sysFilePath(filepath.Join("devices/system/node", dirName, "vmstat")))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Fields(scanner.Text())
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return fmt.Errorf("couldn't parse vmstat field %q for node %s: %w", parts[0], node, err)
+		}
+		// These fields come straight from the kernel with no indication of
+		// which are gauges and which are monotonic counters (several,
+		// e.g. pgsteal_* and pgscan_*, are counters), so report them as
+		// untyped rather than guessing.
+		ch <- prometheus.MustNewConstMetric(c.desc(parts[0]), prometheus.UntypedValue, value, node)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !novmstat_numa
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestVMStatNUMA(t *testing.T) {
+	*sysPath = "fixtures/sys"
+
+	c, err := NewVMStatNUMACollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := c.Update(ch); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	wantDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, vmStatNUMASubsystem, "pgsteal_kswapd"),
+		"Per-node vmstat counter pgsteal_kswapd from /sys/devices/system/node/node*/vmstat.",
+		[]string{"node"}, nil,
+	).String()
+
+	var found bool
+	for m := range ch {
+		if m.Desc().String() != wantDesc {
+			continue
+		}
+		found = true
+
+		var dtoMetric dto.Metric
+		if err := m.Write(&dtoMetric); err != nil {
+			t.Fatal(err)
+		}
+		// pgsteal_kswapd is a monotonic counter, not a gauge -- it must be
+		// reported as untyped since the collector has no way to know that
+		// ahead of time for an arbitrary vmstat field.
+		if dtoMetric.Counter != nil || dtoMetric.Gauge != nil {
+			t.Errorf("expected pgsteal_kswapd to be an untyped metric, got %+v", dtoMetric)
+		}
+		if dtoMetric.Untyped == nil || dtoMetric.Untyped.GetValue() != 1048 {
+			t.Errorf("expected pgsteal_kswapd=1048 as an untyped value, got %+v", dtoMetric.Untyped)
+		}
+	}
+	if !found {
+		t.Error("expected a pgsteal_kswapd metric for node0")
+	}
+}
@@ -20,8 +20,6 @@
 //
 // This collector was tested on RHEL/CentOS 6 and 7 only.
 //
-// TODO: Port to https://github.com/prometheus/procfs
-// TODO: Parse all fields/metrics available in /proc/zoneinfo.
 // TODO: Support all historic variants of the /proc/zoneinfo file format.
 
 // +build !nozoneinfo
@@ -30,250 +28,430 @@ package collector
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
 
 const (
-	zoneInfoSubsystem = "zoneinfo"
+	zoneInfoSubsystem   = "zoneinfo"
+	protectionFieldName = "protection_pages"
 )
 
 var (
-	nodeZoneRE  = regexp.MustCompile(`Node (\d+), zone\s+(\w+)`)
-	zoneInfoMap = map[string]zoneInfoLineDesc{
-		"nr_free_pages": {
+	zoneInfoFieldsInclude = kingpin.Flag("collector.zoneinfo.fields-include", "Regexp of fields to return for the zoneinfo collector.").Default(".*").String()
+	zoneInfoFieldsExclude = kingpin.Flag("collector.zoneinfo.fields-exclude", "Regexp of fields to exclude for the zoneinfo collector.").Default("").String()
+)
+
+// nodeZoneRE picks out the node/zone header line, e.g. "Node 0, zone   DMA".
+var nodeZoneRE = regexp.MustCompile(`Node (\d+), zone\s+(\w+)`)
+
+// protectionRE picks out the lowmem reserve line, e.g.
+// "protection: (0, 2961, 2961, 2961)".
+var protectionRE = regexp.MustCompile(`protection:\s*\(([^)]*)\)`)
+
+// zoneInfoLineDesc describes the metric info for a single field, keyed
+// either by the procfs.Zoneinfo Go struct field name (for fields looked up
+// via reflection) or by the raw /proc/zoneinfo field name (for fields
+// parsed by hand in updateExtra because procfs.Zoneinfo doesn't expose
+// them).
+type zoneInfoLineDesc struct {
+	metricName string
+	metricDesc string
+	metricType prometheus.ValueType
+}
+
+var (
+	// perZoneFieldMap describes metrics that are reported per node and zone,
+	// keyed by the procfs.Zoneinfo struct field name and looked up via
+	// reflection in Update. procfs.Zoneinfo is a flat per-line field switch
+	// with no notion of the kernel's "per-node stats" sub-block, so on
+	// kernels >=4.8 the LRU/isolation counters (nr_active_anon and friends)
+	// only ever appear nested inside that block, not in the zone's own
+	// lines -- and are deliberately NOT in this map. Those are parsed by
+	// hand, once per node, in updateExtra and exposed via nodeStatsFieldMap
+	// instead; if they were also reflected off procfs.Zoneinfo here, the
+	// same metric name would be emitted with two different label sets
+	// (node+zone here, node-only from updateExtra), which a real
+	// prometheus.Registry rejects at Gather time.
+	perZoneFieldMap = map[string]zoneInfoLineDesc{
+		"NrFreePages": {
 			metricName: "free_pages",
 			metricDesc: "Number of free pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"min": {
+		"Min": {
 			metricName: "min_pages",
 			metricDesc: "The min watermark of this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"low": {
+		"Low": {
 			metricName: "low_pages",
 			metricDesc: "The low watermark of this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"high": {
+		"High": {
 			metricName: "high_pages",
 			metricDesc: "The high watermark of this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"scanned": {
+		"Scanned": {
 			metricName: "scanned_pages",
 			metricDesc: "Number of scanned pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"spanned": {
+		"Spanned": {
 			metricName: "spanned_pages",
 			metricDesc: "Number of spanned pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"present": {
+		"Present": {
 			metricName: "present_pages",
 			metricDesc: "Number of present pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"managed": {
+		"Managed": {
 			metricName: "managed_pages",
 			metricDesc: "Number of managed pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// anonymous pages
-		"nr_active_anon": {
-			metricName: "active_anon_pages",
-			metricDesc: "Number of active anonymous pages in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_inactive_anon": {
-			metricName: "inactive_anon_pages",
-			metricDesc: "Number of inactive anonymous pages in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_isolated_anon": {
-			metricName: "isolated_anon_pages",
-			metricDesc: "Number of temporarily isolated pages from anonymous pages LRU in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_anon_pages": {
+		"NrAnonPages": {
 			metricName: "anon_pages",
 			metricDesc: "Number of anonymous pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// special-case: transparent hugepages
-		"nr_anon_transparent_hugepages": {
+		"NrAnonTransparentHugepages": {
 			metricName: "anon_transparent_hugepages",
 			metricDesc: "Number of anonymous transparent_hugepages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// file pages
-		"nr_active_file": {
-			metricName: "active_file_pages",
-			metricDesc: "Number of active pages with file-backing in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_inactive_file": {
-			metricName: "inactive_file_pages",
-			metricDesc: "Number of inactive pages with file-backing in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_isolated_file": {
-			metricName: "isolated_file_pages",
-			metricDesc: "Number of temporarily isolated pages from file-backing pages LRU in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_file_pages": {
+		"NrFilePages": {
 			metricName: "file_pages",
 			metricDesc: "Number of pages with file-backing in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// slab
-		"nr_slab_reclaimable": {
+		"NrSlabReclaimable": {
 			metricName: "reclaimable_slab_pages",
 			metricDesc: "Number of reclaimable slab pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_slab_unreclaimable": {
+		"NrSlabUnreclaimable": {
 			metricName: "unreclaimable_slab_pages",
 			metricDesc: "Number of unreclaimable slab pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// various
-		"nr_mlock_stack": {
+		"NrMlockStack": {
 			metricName: "mlock_pages",
 			metricDesc: "Number of mlock()ed pages found and moved off LRU in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_kernel_stack": {
+		"NrKernelStack": {
 			metricName: "kernel_stack_pages",
 			metricDesc: "Number of kernel stack pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_mapped": {
+		"NrMapped": {
 			metricName: "mapped_pages",
 			metricDesc: "Number of mapped pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_dirty": {
+		"NrDirty": {
 			metricName: "dirty_pages",
 			metricDesc: "Number of dirty pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_writeback": {
+		"NrWriteback": {
 			metricName: "writeback_pages",
 			metricDesc: "Number of writeback pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
-		},
-		"nr_unevictable": {
-			metricName: "unevictable_pages",
-			metricDesc: "Number of unevictable pages in this node and zone",
-			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
-		"nr_shmem": {
+		"NrShmem": {
 			metricName: "shmem_pages",
 			metricDesc: "Number of shmem pages in this node and zone",
 			metricType: prometheus.GaugeValue,
-			valueField: 1,
 		},
 		// counters
-		"nr_dirtied": {
+		"NrDirtied": {
 			metricName: "dirtied_pages_total",
 			metricDesc: "Number of dirtied pages since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"nr_written": {
+		"NrWritten": {
 			metricName: "written_pages_total",
 			metricDesc: "Number of written pages since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
 		// NUMA counters
 		// TODO: Improve descriptions
-		"numa_hit": {
+		"NumaHit": {
 			metricName: "numa_hit_total",
 			metricDesc: "Number of NUMA hit allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"numa_miss": {
+		"NumaMiss": {
 			metricName: "numa_miss_total",
 			metricDesc: "Number of NUMA miss allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"numa_foreign": {
+		"NumaForeign": {
 			metricName: "numa_foreign_total",
 			metricDesc: "Number of NUMA foreign allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"numa_interleave": {
+		"NumaInterleave": {
 			metricName: "numa_interleave_total",
 			metricDesc: "Number of NUMA interleave allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"numa_local": {
+		"NumaLocal": {
 			metricName: "numa_local_total",
 			metricDesc: "Number of NUMA local allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
-		"numa_other": {
+		"NumaOther": {
 			metricName: "numa_other_total",
 			metricDesc: "Number of NUMA other allocations in this node and zone since boot",
 			metricType: prometheus.CounterValue,
-			valueField: 1,
 		},
 	}
 
-	// errors
-	errCantParse = errors.New("can't parse /proc/zoneinfo")
-)
+	// extraZoneFieldMap describes per-node-and-zone metrics that
+	// procfs.Zoneinfo does not expose: the newer (>=4.8) per-zone LRU
+	// fields and the vmscan/workingset reclaim counters. procfs has no
+	// typed representation of these, so they're parsed by hand in
+	// updateExtra and keyed by the raw /proc/zoneinfo field name rather
+	// than a Go struct field name.
+	extraZoneFieldMap = map[string]zoneInfoLineDesc{
+		"nr_zone_anon_lru": {
+			metricName: "zone_anon_lru_pages",
+			metricDesc: "Number of anonymous pages on the LRU in this zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_zone_file_lru": {
+			metricName: "zone_file_lru_pages",
+			metricDesc: "Number of file-backed pages on the LRU in this zone",
+			metricType: prometheus.GaugeValue,
+		},
+		// vmscan / reclaim accounting
+		"pgsteal_kswapd": {
+			metricName: "pgsteal_kswapd_total",
+			metricDesc: "Number of pages reclaimed by kswapd in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"pgsteal_direct": {
+			metricName: "pgsteal_direct_total",
+			metricDesc: "Number of pages reclaimed via direct reclaim in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"pgrefill": {
+			metricName: "pgrefill_total",
+			metricDesc: "Number of pages moved from active to inactive LRU in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"pgscan_kswapd": {
+			metricName: "pgscan_kswapd_total",
+			metricDesc: "Number of pages scanned by kswapd in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"pgscan_direct": {
+			metricName: "pgscan_direct_total",
+			metricDesc: "Number of pages scanned via direct reclaim in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"nr_vmscan_write": {
+			metricName: "vmscan_write_pages",
+			metricDesc: "Number of pages written back by vmscan in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_vmscan_immediate_reclaim": {
+			metricName: "vmscan_immediate_reclaim_pages",
+			metricDesc: "Number of pages requiring immediate reclaim by vmscan in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_writeback_temp": {
+			metricName: "writeback_temp_pages",
+			metricDesc: "Number of temporary writeback pages (FUSE) in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_free_cma": {
+			metricName: "free_cma_pages",
+			metricDesc: "Number of free CMA (Contiguous Memory Allocator) pages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_shmem_hugepages": {
+			metricName: "shmem_hugepages_pages",
+			metricDesc: "Number of shmem transparent hugepages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_shmem_pmdmapped": {
+			metricName: "shmem_pmdmapped_pages",
+			metricDesc: "Number of PMD-mapped shmem transparent hugepages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		// workingset accounting
+		"workingset_refault": {
+			metricName: "workingset_refault_total",
+			metricDesc: "Number of refaults of previously evicted pages in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"workingset_activate": {
+			metricName: "workingset_activate_total",
+			metricDesc: "Number of refaulted pages that were immediately activated in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+		"workingset_nodereclaim": {
+			metricName: "workingset_nodereclaim_total",
+			metricDesc: "Number of times a shadow node has been reclaimed in this node and zone since boot",
+			metricType: prometheus.CounterValue,
+		},
+	}
 
-type zoneInfoCollector struct{}
+	// nodeStatsFieldMap describes metrics reported once per node (no zone
+	// label) via the kernel's "per-node stats" block on kernels >=4.8.
+	// procfs.Zoneinfo has no typed representation of that block, so these
+	// are parsed by hand in updateExtra and keyed by the raw
+	// /proc/zoneinfo field name rather than a Go struct field name. On
+	// pre-4.8 kernels these same counters (nr_active_anon and friends)
+	// appear directly in each zone's own block instead; see
+	// legacyZoneStatsFieldMap for that shape. Either way the metric name
+	// stays the same -- only the label set (node-only here vs. node+zone
+	// there) depends on which the running kernel uses.
+	nodeStatsFieldMap = map[string]zoneInfoLineDesc{
+		"nr_active_anon": {
+			metricName: "active_anon_pages",
+			metricDesc: "Number of active anonymous pages in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_inactive_anon": {
+			metricName: "inactive_anon_pages",
+			metricDesc: "Number of inactive anonymous pages in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_isolated_anon": {
+			metricName: "isolated_anon_pages",
+			metricDesc: "Number of temporarily isolated pages from anonymous pages LRU in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_active_file": {
+			metricName: "active_file_pages",
+			metricDesc: "Number of active pages with file-backing in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_inactive_file": {
+			metricName: "inactive_file_pages",
+			metricDesc: "Number of inactive pages with file-backing in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_isolated_file": {
+			metricName: "isolated_file_pages",
+			metricDesc: "Number of temporarily isolated pages from file-backing pages LRU in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_unevictable": {
+			metricName: "unevictable_pages",
+			metricDesc: "Number of unevictable pages in this node",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_pages_scanned": {
+			metricName: "pages_scanned",
+			metricDesc: "Number of pages scanned since last reclaim in this node",
+			metricType: prometheus.GaugeValue,
+		},
+	}
 
-// zoneInfoLineDesc describes the metric infos of a line in /proc/zoneinfo.
-type zoneInfoLineDesc struct {
-	metricName string
-	metricDesc string
+	// legacyZoneStatsFieldMap is nodeStatsFieldMap's LRU/isolation fields
+	// (everything except nr_pages_scanned, which has no pre-4.8 zone-level
+	// equivalent), re-described with a node+zone label set for kernels
+	// older than 4.8 that report them directly in each zone's own block.
+	// Keeping the metric name identical to nodeStatsFieldMap means a given
+	// series name always means the same thing; only its label set differs
+	// depending on which block shape the running kernel uses.
+	legacyZoneStatsFieldMap = map[string]zoneInfoLineDesc{
+		"nr_active_anon": {
+			metricName: "active_anon_pages",
+			metricDesc: "Number of active anonymous pages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_inactive_anon": {
+			metricName: "inactive_anon_pages",
+			metricDesc: "Number of inactive anonymous pages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_isolated_anon": {
+			metricName: "isolated_anon_pages",
+			metricDesc: "Number of temporarily isolated pages from anonymous pages LRU in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_active_file": {
+			metricName: "active_file_pages",
+			metricDesc: "Number of active pages with file-backing in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_inactive_file": {
+			metricName: "inactive_file_pages",
+			metricDesc: "Number of inactive pages with file-backing in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_isolated_file": {
+			metricName: "isolated_file_pages",
+			metricDesc: "Number of temporarily isolated pages from file-backing pages LRU in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+		"nr_unevictable": {
+			metricName: "unevictable_pages",
+			metricDesc: "Number of unevictable pages in this node and zone",
+			metricType: prometheus.GaugeValue,
+		},
+	}
+)
+
+// zoneInfoMetricDesc pairs a cached *prometheus.Desc with the value type
+// needed to emit it, so Update never has to call prometheus.NewDesc itself.
+type zoneInfoMetricDesc struct {
+	desc       *prometheus.Desc
 	metricType prometheus.ValueType
-	valueField int
-	// type
+}
+
+type zoneInfoCollector struct {
+	fs procfs.FS
+	// rawPath is the /proc/zoneinfo file itself, for the hand-rolled parsing
+	// in updateExtra that procfs.Zoneinfo cannot do. Kept separate from fs
+	// (rather than reopening procFilePath directly) so tests can point it at
+	// a fixture alongside a fixture-backed fs.
+	rawPath string
+
+	// perZoneDescs is built once, at construction time, from
+	// perZoneFieldMap filtered by the fields-include and fields-exclude
+	// flags, and used to look up procfs.Zoneinfo fields via reflection.
+	// nodeStatsDescs/extraZoneDescs are the equivalent caches for the
+	// fields parsed by hand in updateExtra. protectionDesc is nil if
+	// "protection_pages" was filtered out.
+	perZoneDescs   map[string]zoneInfoMetricDesc
+	nodeStatsDescs map[string]zoneInfoMetricDesc
+	extraZoneDescs map[string]zoneInfoMetricDesc
+	protectionDesc *prometheus.Desc
+
+	// legacyZoneStatsDescs is nodeStatsFieldMap's same raw field names, but
+	// with a node+zone label set instead of node-only. Pre-4.8 kernels
+	// report nr_active_anon and friends directly in each zone's own block
+	// rather than nesting them in a "per-node stats" sub-block, so
+	// updateExtra uses this instead of nodeStatsDescs whenever it isn't
+	// currently inside that sub-block. A given running kernel only ever
+	// takes one of these two shapes, so the two descs are never both
+	// emitted in the same Update call.
+	legacyZoneStatsDescs map[string]zoneInfoMetricDesc
 }
 
 func init() {
@@ -282,128 +460,194 @@ func init() {
 
 // NewZoneInfoCollector returns a new Collector exposing zoneinfo stats.
 func NewZoneInfoCollector() (Collector, error) {
-	return &zoneInfoCollector{}, nil
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %w", err)
+	}
+
+	include, err := regexp.Compile(*zoneInfoFieldsInclude)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector.zoneinfo.fields-include: %w", err)
+	}
+	var exclude *regexp.Regexp
+	if *zoneInfoFieldsExclude != "" {
+		exclude, err = regexp.Compile(*zoneInfoFieldsExclude)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collector.zoneinfo.fields-exclude: %w", err)
+		}
+	}
+
+	c := &zoneInfoCollector{
+		fs:                   fs,
+		rawPath:              procFilePath("zoneinfo"),
+		perZoneDescs:         buildZoneInfoDescs(perZoneFieldMap, []string{"node", "zone"}, include, exclude),
+		nodeStatsDescs:       buildZoneInfoDescs(nodeStatsFieldMap, []string{"node"}, include, exclude),
+		extraZoneDescs:       buildZoneInfoDescs(extraZoneFieldMap, []string{"node", "zone"}, include, exclude),
+		legacyZoneStatsDescs: buildZoneInfoDescs(legacyZoneStatsFieldMap, []string{"node", "zone"}, include, exclude),
+	}
+	if zoneInfoFieldAllowed(protectionFieldName, include, exclude) {
+		c.protectionDesc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, zoneInfoSubsystem, protectionFieldName),
+			"Lowmem reserve (protection) pages for this node, zone and allocation order.",
+			[]string{"node", "zone", "order"}, nil,
+		)
+	}
+	return c, nil
+}
+
+// zoneInfoFieldAllowed reports whether metricName survives the include and
+// exclude filters. Exclude always wins over include.
+func zoneInfoFieldAllowed(metricName string, include, exclude *regexp.Regexp) bool {
+	if exclude != nil && exclude.MatchString(metricName) {
+		return false
+	}
+	return include.MatchString(metricName)
 }
 
-// # cat /proc/zoneinfo |grep ^Node -A 9
-// Node 0, zone      DMA
-//   per-node stats                 \
-//       nr_inactive_anon 72251      \ optional
-//       ...                         /
-//       nr_active_anon 61316       /
-//   pages free     3965
-//         min      3
-//         low      3
-//         high     4
-//         scanned  0
-//         spanned  4095
-//         present  3990
-//         managed  3969
-//     nr_free_pages 3965
-// --
-// Node 0, zone    DMA32
-//   pages free     46089
-//         min      654
-//         low      817
-//         high     981
-//         scanned  0
-//         spanned  1044480
-//         present  780160
-//         managed  717695
-//     nr_free_pages 46089
-// --
-// Node 0, zone   Normal
-//   pages free     241793
-//         min      10576
-//         low      13220
-//         high     15864
-//         scanned  0
-//         spanned  11796480
-//         present  11796480
-//         managed  11599355
-//     nr_free_pages 241793
-// --
-// Node 1, zone   Normal
-//   pages free     34688
-//         min      11293
-//         low      14116
-//         high     16939
-//         scanned  0
-//         spanned  12582912
-//         present  12582912
-//         managed  12385727
-//     nr_free_pages 34688
+// buildZoneInfoDescs filters fields by metric name and builds a Desc for
+// each survivor once, up front, so Update can reuse it on every scrape.
+func buildZoneInfoDescs(fields map[string]zoneInfoLineDesc, labels []string, include, exclude *regexp.Regexp) map[string]zoneInfoMetricDesc {
+	descs := make(map[string]zoneInfoMetricDesc, len(fields))
+	for field, ld := range fields {
+		if !zoneInfoFieldAllowed(ld.metricName, include, exclude) {
+			continue
+		}
+		descs[field] = zoneInfoMetricDesc{
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, zoneInfoSubsystem, ld.metricName),
+				ld.metricDesc,
+				labels, nil,
+			),
+			metricType: ld.metricType,
+		}
+	}
+	return descs
+}
 
 func (c *zoneInfoCollector) Update(ch chan<- prometheus.Metric) error {
-	file, err := os.Open(procFilePath("zoneinfo"))
+	zoneInfo, err := c.fs.Zoneinfo()
+	if err != nil {
+		return fmt.Errorf("couldn't get zoneinfo: %w", err)
+	}
+
+	for _, zi := range zoneInfo {
+		v := reflect.ValueOf(zi)
+		for name, md := range c.perZoneDescs {
+			fv := v.FieldByName(name)
+			if !fv.IsValid() || fv.Kind() != reflect.Ptr || fv.IsNil() {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(md.desc, md.metricType, float64(fv.Elem().Int()), zi.Node, zi.Zone)
+		}
+	}
+
+	// procfs.Zoneinfo only covers the fields above -- it has no notion of
+	// the kernel's "per-node stats" block, the lowmem protection array, or
+	// the vmscan/workingset counters, so those are parsed from the raw file
+	// by hand here instead.
+	return c.updateExtra(ch)
+}
+
+// updateExtra parses /proc/zoneinfo by hand for the fields procfs.Zoneinfo
+// doesn't expose: the per-node LRU/isolation counters on kernels >=4.8
+// (nodeStatsDescs) or their pre-4.8 per-zone equivalent
+// (legacyZoneStatsDescs), the per-zone lowmem protection array, and the
+// per-zone vmscan/workingset/LRU counters (extraZoneDescs).
+func (c *zoneInfoCollector) updateExtra(ch chan<- prometheus.Metric) error {
+	file, err := os.Open(c.rawPath)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	var node, zone string
+	inNodeStats := false
+	skipNodeStats := false
+	// Kernels >=4.8 repeat the per-node stats block verbatim in every zone
+	// of a node; only emit it once per node.
+	seenNodeStats := map[string]bool{}
+
 	scanner := bufio.NewScanner(file)
-	node := "Unknown"
-	zone := "Unknown"
-	perNodeStat := false
 	for scanner.Scan() {
-		var value float64
-		var err error
 		line := strings.TrimSpace(scanner.Text())
-		if nodeZone := nodeZoneRE.FindStringSubmatch(line); nodeZone != nil {
-			node = nodeZone[1]
-			zone = nodeZone[2]
+
+		if m := nodeZoneRE.FindStringSubmatch(line); m != nil {
+			node, zone = m[1], m[2]
+			inNodeStats = false
 			continue
 		}
 		if strings.HasPrefix(line, "per-node stats") {
-			perNodeStat = true
+			inNodeStats = true
+			skipNodeStats = seenNodeStats[node]
 			continue
 		}
 		if strings.HasPrefix(line, "pages free") {
-			perNodeStat = false
+			if inNodeStats && !skipNodeStats {
+				seenNodeStats[node] = true
+			}
+			inNodeStats = false
+			continue
+		}
+		if m := protectionRE.FindStringSubmatch(line); m != nil {
+			if err := c.emitProtection(ch, node, zone, m[1]); err != nil {
+				return err
+			}
 			continue
 		}
+
 		parts := strings.Fields(line)
-		if len(parts) < 2 {
+		if len(parts) != 2 {
 			continue
 		}
-		ld, found := zoneInfoMap[parts[0]]
-		if !found {
+
+		if inNodeStats {
+			if skipNodeStats {
+				continue
+			}
+			md, ok := c.nodeStatsDescs[parts[0]]
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil {
+				return fmt.Errorf("couldn't parse zoneinfo field %q: %w", parts[0], err)
+			}
+			ch <- prometheus.MustNewConstMetric(md.desc, md.metricType, value, node)
 			continue
 		}
-		metric := ld.metricName
-		desc := ld.metricDesc
-		if value, err = strconv.ParseFloat(parts[ld.valueField], 64); err != nil {
-			return fmt.Errorf("can't parse /proc/zoneinfo: %s", err)
+
+		// Not inside a "per-node stats" block: this is either an
+		// extraZoneDescs field, or (pre-4.8 kernels only) one of the
+		// LRU/isolation counters reported directly in the zone's own
+		// block instead of a per-node sub-block.
+		md, ok := c.extraZoneDescs[parts[0]]
+		if !ok {
+			md, ok = c.legacyZoneStatsDescs[parts[0]]
+			if !ok {
+				continue
+			}
 		}
-		if perNodeStat {
-			// per-node metric
-			ch <- prometheus.MustNewConstMetric(
-				prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, zoneInfoSubsystem, metric),
-					desc,
-					[]string{"node"}, nil,
-				),
-				ld.metricType,
-				value,
-				node,
-			)
-		} else {
-			// node and zone metric
-			ch <- prometheus.MustNewConstMetric(
-				prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, zoneInfoSubsystem, metric),
-					desc,
-					[]string{"node", "zone"}, nil,
-				),
-				ld.metricType,
-				value,
-				node,
-				zone,
-			)
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return fmt.Errorf("couldn't parse zoneinfo field %q: %w", parts[0], err)
 		}
-	}
-	if node == "Unknown" {
-		return errCantParse
+		ch <- prometheus.MustNewConstMetric(md.desc, md.metricType, value, node, zone)
 	}
 	return scanner.Err()
 }
+
+// emitProtection parses a "protection: (a, b, c, ...)" line into one gauge
+// per allocation order.
+func (c *zoneInfoCollector) emitProtection(ch chan<- prometheus.Metric, node, zone, rawValues string) error {
+	if c.protectionDesc == nil {
+		return nil
+	}
+	for order, raw := range strings.Split(rawValues, ",") {
+		value, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return fmt.Errorf("couldn't parse zoneinfo protection field: %w", err)
+		}
+		ch <- prometheus.MustNewConstMetric(c.protectionDesc, prometheus.GaugeValue, value, node, zone, strconv.Itoa(order))
+	}
+	return nil
+}
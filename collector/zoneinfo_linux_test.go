@@ -0,0 +1,271 @@
+// Copyright 2017 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nozoneinfo
+
+package collector
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/procfs"
+)
+
+var matchAllRE = regexp.MustCompile(".*")
+
+// newTestZoneInfoCollector builds a zoneInfoCollector against the given
+// fixture, with its desc caches filtered the same way NewZoneInfoCollector
+// would, without depending on the kingpin flag values.
+func newTestZoneInfoCollector(t *testing.T, fixture string, include, exclude *regexp.Regexp) *zoneInfoCollector {
+	t.Helper()
+
+	src, err := ioutil.ReadFile(filepath.Join("fixtures", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	rawPath := filepath.Join(dir, "zoneinfo")
+	if err := ioutil.WriteFile(rawPath, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := procfs.NewFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &zoneInfoCollector{
+		fs:                   fs,
+		rawPath:              rawPath,
+		perZoneDescs:         buildZoneInfoDescs(perZoneFieldMap, []string{"node", "zone"}, include, exclude),
+		nodeStatsDescs:       buildZoneInfoDescs(nodeStatsFieldMap, []string{"node"}, include, exclude),
+		extraZoneDescs:       buildZoneInfoDescs(extraZoneFieldMap, []string{"node", "zone"}, include, exclude),
+		legacyZoneStatsDescs: buildZoneInfoDescs(legacyZoneStatsFieldMap, []string{"node", "zone"}, include, exclude),
+	}
+	if zoneInfoFieldAllowed(protectionFieldName, include, exclude) {
+		c.protectionDesc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, zoneInfoSubsystem, protectionFieldName),
+			"Lowmem reserve (protection) pages for this node, zone and allocation order.",
+			[]string{"node", "zone", "order"}, nil,
+		)
+	}
+	return c
+}
+
+// collectZoneInfoLabelSets runs the collector against the given fixture and
+// returns, for each emitted metric descriptor (keyed by Desc().String()),
+// the set of label keys it was emitted with -- this is what must stay
+// stable across kernel versions.
+func collectZoneInfoLabelSets(t *testing.T, fixture string) map[string]map[string]bool {
+	t.Helper()
+
+	c := newTestZoneInfoCollector(t, fixture, matchAllRE, nil)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := c.Update(ch); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	labelSets := map[string]map[string]bool{}
+	for m := range ch {
+		var dtoMetric dto.Metric
+		if err := m.Write(&dtoMetric); err != nil {
+			t.Fatal(err)
+		}
+		name := m.Desc().String()
+		if labelSets[name] == nil {
+			labelSets[name] = map[string]bool{}
+		}
+		for _, l := range dtoMetric.Label {
+			labelSets[name][l.GetName()] = true
+		}
+	}
+	return labelSets
+}
+
+func TestZoneInfoLabelStability(t *testing.T) {
+	pre := collectZoneInfoLabelSets(t, "zoneinfo_pre_4_8")
+	post := collectZoneInfoLabelSets(t, "zoneinfo_post_4_8")
+
+	for name, preLabels := range pre {
+		postLabels, ok := post[name]
+		if !ok {
+			// Only true per-zone legacy fields are expected to disappear
+			// from the zone block once a kernel reports per-node stats.
+			continue
+		}
+		for l := range preLabels {
+			if !postLabels[l] {
+				t.Errorf("metric %s lost label %q between pre- and post-4.8 fixtures", name, l)
+			}
+		}
+	}
+}
+
+func TestZoneInfoPerNodeDedup(t *testing.T) {
+	// The post-4.8 fixture has two zones on node 0 that both carry an
+	// identical "per-node stats" block; node-scoped metrics like
+	// inactive_anon_pages must be emitted once for node 0, not once per zone.
+	c := newTestZoneInfoCollector(t, "zoneinfo_post_4_8", matchAllRE, nil)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := c.Update(ch); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	wantDesc := prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, zoneInfoSubsystem, "inactive_anon_pages"),
+		"Number of inactive anonymous pages in this node",
+		[]string{"node"}, nil,
+	).String()
+
+	count := 0
+	for m := range ch {
+		if m.Desc().String() == wantDesc {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected inactive_anon_pages to be emitted once for node 0, got %d", count)
+	}
+}
+
+func TestZoneInfoProtectionAndVmscan(t *testing.T) {
+	// These fields have no typed representation in procfs.Zoneinfo and are
+	// parsed by hand in updateExtra; make sure they actually come through,
+	// and that the protection array is split into one sample per order.
+	c := newTestZoneInfoCollector(t, "zoneinfo_post_4_8", matchAllRE, nil)
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		defer close(ch)
+		if err := c.Update(ch); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	protectionDesc := c.protectionDesc.String()
+	workingsetDesc := c.extraZoneDescs["workingset_refault"].desc.String()
+	pgstealDesc := c.extraZoneDescs["pgsteal_kswapd"].desc.String()
+
+	var protectionSamples, workingsetSamples int
+	var sawPgstealDMA bool
+	for m := range ch {
+		switch m.Desc().String() {
+		case protectionDesc:
+			protectionSamples++
+		case workingsetDesc:
+			workingsetSamples++
+		case pgstealDesc:
+			var dtoMetric dto.Metric
+			if err := m.Write(&dtoMetric); err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range dtoMetric.Label {
+				if l.GetName() == "zone" && l.GetValue() == "DMA" {
+					sawPgstealDMA = true
+				}
+			}
+			// pgsteal_kswapd is a reclaim counter, never a gauge.
+			if dtoMetric.Counter == nil || dtoMetric.Gauge != nil {
+				t.Errorf("expected pgsteal_kswapd_total to be a counter, got %+v", dtoMetric)
+			}
+		}
+	}
+	if want := 4; protectionSamples != want {
+		t.Errorf("expected %d protection_pages samples (one per allocation order) for the DMA zone, got %d", want, protectionSamples)
+	}
+	if workingsetSamples == 0 {
+		t.Error("expected at least one workingset_refault_total sample")
+	}
+	if !sawPgstealDMA {
+		t.Error("expected a pgsteal_kswapd_total sample labeled zone=DMA")
+	}
+}
+
+// zoneInfoUpdateFunc adapts zoneInfoCollector.Update to prometheus.Collector
+// so it can be registered with a real prometheus.Registry and Gather()ed --
+// Gather is what actually rejects a metric name emitted with two different
+// label dimensions, which comparing Desc().String() values (as the other
+// tests in this file do) cannot catch.
+type zoneInfoUpdateFunc func(chan<- prometheus.Metric) error
+
+func (f zoneInfoUpdateFunc) Describe(ch chan<- *prometheus.Desc) {}
+
+func (f zoneInfoUpdateFunc) Collect(ch chan<- prometheus.Metric) {
+	if err := f(ch); err != nil {
+		panic(err)
+	}
+}
+
+func TestZoneInfoGathersCleanly(t *testing.T) {
+	for _, fixture := range []string{"zoneinfo_pre_4_8", "zoneinfo_post_4_8"} {
+		t.Run(fixture, func(t *testing.T) {
+			c := newTestZoneInfoCollector(t, fixture, matchAllRE, nil)
+
+			reg := prometheus.NewRegistry()
+			if err := reg.Register(zoneInfoUpdateFunc(c.Update)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := reg.Gather(); err != nil {
+				t.Errorf("Gather() on %s fixture returned an error (likely the same metric name emitted with two different label sets): %v", fixture, err)
+			}
+		})
+	}
+}
+
+func TestZoneInfoFieldsIncludeExclude(t *testing.T) {
+	includeAnon := regexp.MustCompile("anon")
+
+	c := newTestZoneInfoCollector(t, "zoneinfo_post_4_8", includeAnon, nil)
+	if _, ok := c.perZoneDescs["NrFreePages"]; ok {
+		t.Error("expected free_pages to be filtered out by fields-include")
+	}
+	if _, ok := c.nodeStatsDescs["nr_active_anon"]; !ok {
+		t.Error("expected active_anon_pages to survive fields-include=anon")
+	}
+	if _, ok := c.extraZoneDescs["nr_zone_anon_lru"]; !ok {
+		t.Error("expected zone_anon_lru_pages to survive fields-include=anon")
+	}
+
+	// Exclude wins over include when both match.
+	excludeActive := regexp.MustCompile("^active_anon")
+	c = newTestZoneInfoCollector(t, "zoneinfo_post_4_8", includeAnon, excludeActive)
+	if _, ok := c.nodeStatsDescs["nr_active_anon"]; ok {
+		t.Error("expected fields-exclude to win over fields-include for active_anon_pages")
+	}
+	if _, ok := c.nodeStatsDescs["nr_inactive_anon"]; !ok {
+		t.Error("expected inactive_anon_pages to still survive, only active_anon_pages is excluded")
+	}
+
+	// legacyZoneStatsDescs is built from the same filters as nodeStatsDescs
+	// and must be filtered identically, since only one of the two is ever
+	// populated for a given kernel.
+	if _, ok := c.legacyZoneStatsDescs["nr_active_anon"]; ok {
+		t.Error("expected fields-exclude to win over fields-include for legacy active_anon_pages too")
+	}
+	if _, ok := c.legacyZoneStatsDescs["nr_inactive_anon"]; !ok {
+		t.Error("expected legacy inactive_anon_pages to still survive, only active_anon_pages is excluded")
+	}
+}